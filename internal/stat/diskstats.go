@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/lesovsky/pgcenter/internal/postgres"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -41,6 +43,12 @@ type Diskstat struct {
 	Arqsz     float64 // average size (in sectors) of the requests that were issued to the device.
 	Util      float64 // percentage of elapsed time during which I/O requests were issued to the device (bandwidth utilization for the device). Device saturation occurs when this value is close to 100% for devices serving requests serially.
 	// But for devices serving requests in parallel, such as RAID arrays and modern SSDs, this number does not reflect their performance limits.
+	Aqusz   float64 // average queue size (aqu-sz), derived from the weighted time delta. Meaningful for parallel devices where Util saturates early.
+	Rutil   float64 // percentage of elapsed time during which read requests were issued to the device.
+	Wutil   float64 // percentage of elapsed time during which write requests were issued to the device.
+	Dutil   float64 // percentage of elapsed time during which discard requests were issued to the device.
+	Queues  int     // number of hardware submission queues; 1 for single-queue (classic) devices, as reported by /sys/block/<dev>/mq.
+	MqUtil  float64 // Util scaled by Queues. Util alone only tracks whether at least one request was in flight, so on a multi-queue device it saturates at 100% long before the device itself does; MqUtil approximates per-queue saturation instead.
 }
 
 // Diskstats is the container for all stats related to all block devices
@@ -53,17 +61,56 @@ const (
 	pgProcDiskstatsQuery = "SELECT * FROM pgcenter.sys_proc_diskstats ORDER BY (maj,min)"
 )
 
-func readDiskstats(db *postgres.DB, schemaExists bool) (Diskstats, error) {
+// pseudoDeviceRegexp matches pseudo block devices which are dropped by
+// default (ram disks, loop devices, loopback-backed fd devices). Compiled
+// once at package init instead of per-line, since it never changes.
+var pseudoDeviceRegexp = regexp.MustCompile(`^(ram|loop|fd)`)
+
+// Config holds runtime-configurable knobs for stat collection.
+type Config struct {
+	// DiskstatsInclude, when non-empty, restricts diskstats collection to
+	// devices matching at least one of these filepath.Match-style globs
+	// (e.g. "nvme*", "dm-*"), overriding the default pseudo-device filter.
+	DiskstatsInclude []string
+	// DiskstatsExclude drops devices matching any of these globs, applied
+	// on top of DiskstatsInclude (e.g. to hide "dm-*" shadow devices).
+	DiskstatsExclude []string
+}
+
+// wantDiskstatsDevice reports whether a device should be kept, given cfg's
+// include/exclude globs. Exclude globs always apply. With no include globs
+// configured, it falls back to dropping pseudo devices (ram/loop/fd),
+// preserving the historical default.
+func wantDiskstatsDevice(device string, cfg Config) bool {
+	for _, pattern := range cfg.DiskstatsExclude {
+		if ok, _ := filepath.Match(pattern, device); ok {
+			return false
+		}
+	}
+
+	if len(cfg.DiskstatsInclude) > 0 {
+		for _, pattern := range cfg.DiskstatsInclude {
+			if ok, _ := filepath.Match(pattern, device); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !pseudoDeviceRegexp.MatchString(device)
+}
+
+func ReadDiskstats(db *postgres.DB, schemaExists bool, cfg Config) (Diskstats, error) {
 	if db.Local {
-		return readDiskstatsLocal("/proc/diskstats")
+		return readDiskstatsLocal("/proc/diskstats", cfg)
 	} else if schemaExists {
-		return readDiskstatsRemote(db)
+		return readDiskstatsRemote(db, cfg)
 	}
 
 	return Diskstats{}, nil
 }
 
-func readDiskstatsLocal(statfile string) (Diskstats, error) {
+func readDiskstatsLocal(statfile string, cfg Config) (Diskstats, error) {
 	var stat Diskstats
 	f, err := os.Open(statfile)
 	if err != nil {
@@ -117,20 +164,32 @@ func readDiskstatsLocal(statfile string) (Diskstats, error) {
 			return nil, fmt.Errorf("%s bad content: %w", statfile, err)
 		}
 
-		// skip pseudo block devices.
-		re := regexp.MustCompile(`^(ram|loop|fd)`)
-		if re.MatchString(d.Device) {
+		if !wantDiskstatsDevice(d.Device, cfg) {
 			continue
 		}
 
 		d.Uptime = uptime
+		d.Queues = readDiskstatsQueues(d.Device)
 		stat = append(stat, d)
 	}
 
 	return stat, nil
 }
 
-func readDiskstatsRemote(db *postgres.DB) (Diskstats, error) {
+// readDiskstatsQueues returns the number of hardware submission queues for a
+// block device, based on the per-queue directories under /sys/block/<dev>/mq.
+// Single-queue (classic) devices, and devices whose sysfs info can't be read,
+// report 1.
+func readDiskstatsQueues(device string) int {
+	entries, err := os.ReadDir(fmt.Sprintf("/sys/block/%s/mq", device))
+	if err != nil || len(entries) == 0 {
+		return 1
+	}
+
+	return len(entries)
+}
+
+func readDiskstatsRemote(db *postgres.DB, cfg Config) (Diskstats, error) {
 	var uptime float64
 	err := db.QueryRow(pgProcUptimeQuery).Scan(&uptime)
 	if err != nil {
@@ -157,76 +216,143 @@ func readDiskstatsRemote(db *postgres.DB) (Diskstats, error) {
 			return nil, err
 		}
 
-		// skip pseudo block devices.
-		re := regexp.MustCompile(`^(ram|loop|fd)`)
-		if re.MatchString(d.Device) {
+		if !wantDiskstatsDevice(d.Device, cfg) {
 			continue
 		}
 
+		// Remote mode has no access to the monitored host's sysfs, so
+		// hardware queue count is unknown; assume classic single-queue.
 		d.Uptime = uptime
+		d.Queues = 1
 		stat = append(stat, d)
 	}
 
 	return stat, nil
 }
 
-func countDiskstatsUsage(prev Diskstats, curr Diskstats, ticks float64) Diskstats {
-	if len(curr) != len(prev) {
-		// TODO: make possible to diff snapshots with different number of devices.
-		return nil
+// diskstatsKey uniquely identifies a device across snapshots. Major/Minor is
+// preferred since it's stable across renames; Device is kept as a fallback
+// for sources which don't provide it (shouldn't happen for /proc/diskstats
+// or pgcenter.sys_proc_diskstats, but keeps the join total).
+type diskstatsKey struct {
+	major, minor int
+	device       string
+}
+
+func newDiskstatsKey(d Diskstat) diskstatsKey {
+	if d.Major == 0 && d.Minor == 0 {
+		return diskstatsKey{device: d.Device}
 	}
+	return diskstatsKey{major: d.Major, minor: d.Minor}
+}
 
-	stat := make([]Diskstat, len(curr))
+// CountDiskstatsUsage computes deltas between two diskstats snapshots.
+//
+// Devices are joined by (Major, Minor) - falling back to Device name - rather
+// than by index, so a device appearing or disappearing between samples (NVMe
+// hot-add, LVM snapshot, loopback for a temp tablespace) no longer blanks out
+// the whole result. A device present only in curr is reported as first-seen,
+// with zeroed rates; a device present only in prev is dropped. Devices keep
+// their (Major, Minor) ordering across ticks so the top UI doesn't reshuffle.
+func CountDiskstatsUsage(prev Diskstats, curr Diskstats, ticks float64) Diskstats {
+	prevByKey := make(map[diskstatsKey]Diskstat, len(prev))
+	for _, p := range prev {
+		prevByKey[newDiskstatsKey(p)] = p
+	}
 
-	for i := 0; i < len(curr); i++ {
+	// Sort a copy - curr is the caller's slice and becomes next tick's prev,
+	// so reordering it in place here would be a surprising side effect.
+	sorted := make(Diskstats, len(curr))
+	copy(sorted, curr)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Major != sorted[j].Major {
+			return sorted[i].Major < sorted[j].Major
+		}
+		return sorted[i].Minor < sorted[j].Minor
+	})
+
+	stat := make(Diskstats, 0, len(sorted))
+
+	for _, c := range sorted {
 		// Skip inactive devices.
-		if curr[i].Rcompleted+curr[i].Wcompleted == 0 {
+		if c.Rcompleted+c.Wcompleted == 0 {
 			continue
 		}
 
-		stat[i].Major = curr[i].Major
-		stat[i].Minor = curr[i].Minor
-		stat[i].Device = curr[i].Device
-		itv := curr[i].Uptime - prev[i].Uptime
+		d := Diskstat{Major: c.Major, Minor: c.Minor, Device: c.Device, Queues: c.Queues}
+
+		p, ok := prevByKey[newDiskstatsKey(c)]
+		if !ok {
+			// First time we see this device - report it, but there's no
+			// previous sample to diff against yet.
+			stat = append(stat, d)
+			continue
+		}
 
-		stat[i].Completed = curr[i].Rcompleted + curr[i].Wcompleted
+		itv := c.Uptime - p.Uptime
 
-		stat[i].Util = sValue(prev[i].Tspent, curr[i].Tspent, itv, ticks) / 10
+		d.Completed = c.Rcompleted + c.Wcompleted
 
-		if ((curr[i].Rcompleted + curr[i].Wcompleted) - (prev[i].Rcompleted + prev[i].Wcompleted)) > 0 {
-			stat[i].Await = ((curr[i].Rspent - prev[i].Rspent) + (curr[i].Wspent - prev[i].Wspent)) /
-				((curr[i].Rcompleted + curr[i].Wcompleted) - (prev[i].Rcompleted + prev[i].Wcompleted))
+		d.Util = sValue(p.Tspent, c.Tspent, itv, ticks) / 10
+
+		// Scale utilization against the number of hardware queues: a device
+		// with N queues can have N requests in flight at once, so dividing
+		// by N approximates the saturation of any single queue.
+		if d.Queues > 1 {
+			d.MqUtil = d.Util / float64(d.Queues)
 		} else {
-			stat[i].Await = 0
+			d.MqUtil = d.Util
 		}
 
-		if ((curr[i].Rcompleted + curr[i].Wcompleted) - (prev[i].Rcompleted + prev[i].Wcompleted)) > 0 {
-			stat[i].Arqsz = ((curr[i].Rsectors - prev[i].Rsectors) + (curr[i].Wsectors - prev[i].Wsectors)) /
-				((curr[i].Rcompleted + curr[i].Wcompleted) - (prev[i].Rcompleted + prev[i].Wcompleted))
-		} else {
-			stat[i].Arqsz = 0
+		// Per-component utilization - useful on its own, and to tell apart a
+		// read-bound from a write-bound device when Util is saturated.
+		d.Rutil = sValue(p.Rspent, c.Rspent, itv, ticks) / 10
+		d.Wutil = sValue(p.Wspent, c.Wspent, itv, ticks) / 10
+		d.Dutil = sValue(p.Dspent, c.Dspent, itv, ticks) / 10
+
+		// Average queue size (aqu-sz). Unlike Util, this keeps being meaningful
+		// for multi-queue devices (NVMe, RAID) where Util saturates at 100%
+		// long before the device itself is actually saturated.
+		d.Aqusz = sValue(p.Tweighted, c.Tweighted, itv, ticks) / 1000
+
+		if (c.Rcompleted+c.Wcompleted)-(p.Rcompleted+p.Wcompleted) > 0 {
+			d.Await = ((c.Rspent - p.Rspent) + (c.Wspent - p.Wspent)) /
+				((c.Rcompleted + c.Wcompleted) - (p.Rcompleted + p.Wcompleted))
 		}
 
-		if (curr[i].Rcompleted - prev[i].Rcompleted) > 0 {
-			stat[i].Rawait = (curr[i].Rspent - prev[i].Rspent) / (curr[i].Rcompleted - prev[i].Rcompleted)
-		} else {
-			stat[i].Rawait = 0
+		if (c.Rcompleted+c.Wcompleted)-(p.Rcompleted+p.Wcompleted) > 0 {
+			d.Arqsz = ((c.Rsectors - p.Rsectors) + (c.Wsectors - p.Wsectors)) /
+				((c.Rcompleted + c.Wcompleted) - (p.Rcompleted + p.Wcompleted))
 		}
 
-		if (curr[i].Wcompleted - prev[i].Wcompleted) > 0 {
-			stat[i].Wawait = (curr[i].Wspent - prev[i].Wspent) / (curr[i].Wcompleted - prev[i].Wcompleted)
-		} else {
-			stat[i].Wawait = 0
+		if c.Rcompleted-p.Rcompleted > 0 {
+			d.Rawait = (c.Rspent - p.Rspent) / (c.Rcompleted - p.Rcompleted)
 		}
 
-		stat[i].Rmerged = sValue(prev[i].Rmerged, curr[i].Rmerged, itv, ticks)
-		stat[i].Wmerged = sValue(prev[i].Wmerged, curr[i].Wmerged, itv, ticks)
-		stat[i].Rcompleted = sValue(prev[i].Rcompleted, curr[i].Rcompleted, itv, ticks)
-		stat[i].Wcompleted = sValue(prev[i].Wcompleted, curr[i].Wcompleted, itv, ticks)
-		stat[i].Rsectors = sValue(prev[i].Rsectors, curr[i].Rsectors, itv, ticks) / 2048
-		stat[i].Wsectors = sValue(prev[i].Wsectors, curr[i].Wsectors, itv, ticks) / 2048
-		stat[i].Tweighted = sValue(prev[i].Tweighted, curr[i].Tweighted, itv, ticks) / 1000
+		if c.Wcompleted-p.Wcompleted > 0 {
+			d.Wawait = (c.Wspent - p.Wspent) / (c.Wcompleted - p.Wcompleted)
+		}
+
+		d.Rmerged = sValue(p.Rmerged, c.Rmerged, itv, ticks)
+		d.Wmerged = sValue(p.Wmerged, c.Wmerged, itv, ticks)
+		d.Rcompleted = sValue(p.Rcompleted, c.Rcompleted, itv, ticks)
+		d.Wcompleted = sValue(p.Wcompleted, c.Wcompleted, itv, ticks)
+		d.Rsectors = sValue(p.Rsectors, c.Rsectors, itv, ticks) / 2048
+		d.Wsectors = sValue(p.Wsectors, c.Wsectors, itv, ticks) / 2048
+		d.Tweighted = sValue(p.Tweighted, c.Tweighted, itv, ticks) / 1000
+
+		stat = append(stat, d)
 	}
 
 	return stat
 }
+
+// UtilForDisplay returns the utilization value to show in the iostat view:
+// the classic Util, or the multi-queue-aware MqUtil when multiQueue is true.
+// Lets callers (e.g. 'top's iostat panel) toggle between the two displays.
+func (d Diskstat) UtilForDisplay(multiQueue bool) float64 {
+	if multiQueue {
+		return d.MqUtil
+	}
+	return d.Util
+}
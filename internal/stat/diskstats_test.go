@@ -0,0 +1,151 @@
+package stat
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_countDiskstatsUsage(t *testing.T) {
+	testcases := []struct {
+		name       string
+		prev, curr Diskstats
+		wantOrder  []string // expected Device order in the result
+	}{
+		{
+			name: "device appears",
+			prev: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda", Rcompleted: 100, Uptime: 10},
+			},
+			curr: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda", Rcompleted: 200, Uptime: 20},
+				{Major: 259, Minor: 0, Device: "nvme0n1", Rcompleted: 5, Uptime: 20},
+			},
+			wantOrder: []string{"sda", "nvme0n1"},
+		},
+		{
+			name: "device disappears",
+			prev: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda", Rcompleted: 100, Uptime: 10},
+				{Major: 8, Minor: 16, Device: "sdb", Rcompleted: 50, Uptime: 10},
+			},
+			curr: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda", Rcompleted: 200, Uptime: 20},
+			},
+			wantOrder: []string{"sda"},
+		},
+		{
+			name: "major/minor collision after rename",
+			prev: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda-old", Rcompleted: 100, Uptime: 10},
+			},
+			curr: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda-new", Rcompleted: 300, Uptime: 20},
+			},
+			wantOrder: []string{"sda-new"},
+		},
+		{
+			name: "same count but reordered",
+			prev: Diskstats{
+				{Major: 8, Minor: 0, Device: "sda", Rcompleted: 100, Uptime: 10},
+				{Major: 259, Minor: 0, Device: "nvme0n1", Rcompleted: 100, Uptime: 10},
+			},
+			curr: Diskstats{
+				{Major: 259, Minor: 0, Device: "nvme0n1", Rcompleted: 200, Uptime: 20},
+				{Major: 8, Minor: 0, Device: "sda", Rcompleted: 250, Uptime: 20},
+			},
+			wantOrder: []string{"sda", "nvme0n1"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := CountDiskstatsUsage(tc.prev, tc.curr, 100)
+
+			devices := make([]string, len(got))
+			for i, d := range got {
+				devices[i] = d.Device
+			}
+
+			assert.Equal(t, tc.wantOrder, devices)
+		})
+	}
+}
+
+func Test_wantDiskstatsDevice(t *testing.T) {
+	testcases := []struct {
+		name   string
+		device string
+		cfg    Config
+		want   bool
+	}{
+		{name: "default drops loop", device: "loop0", cfg: Config{}, want: false},
+		{name: "default drops ram", device: "ram0", cfg: Config{}, want: false},
+		{name: "default keeps sda", device: "sda", cfg: Config{}, want: true},
+		{
+			name:   "include overrides default pseudo-device filter",
+			device: "loop0",
+			cfg:    Config{DiskstatsInclude: []string{"loop*"}},
+			want:   true,
+		},
+		{
+			name:   "include list drops devices not matching",
+			device: "sda",
+			cfg:    Config{DiskstatsInclude: []string{"nvme*"}},
+			want:   false,
+		},
+		{
+			name:   "exclude drops dm-* shadow devices",
+			device: "dm-0",
+			cfg:    Config{DiskstatsExclude: []string{"dm-*"}},
+			want:   false,
+		},
+		{
+			name:   "exclude wins over include",
+			device: "dm-0",
+			cfg:    Config{DiskstatsInclude: []string{"dm-*"}, DiskstatsExclude: []string{"dm-*"}},
+			want:   false,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, wantDiskstatsDevice(tc.device, tc.cfg))
+		})
+	}
+}
+
+func Test_countDiskstatsUsage_multiQueue(t *testing.T) {
+	prev := Diskstats{
+		{Major: 259, Minor: 0, Device: "nvme0n1", Queues: 4, Rspent: 100, Wspent: 50, Tspent: 150, Tweighted: 300, Uptime: 10},
+	}
+	curr := Diskstats{
+		{Major: 259, Minor: 0, Device: "nvme0n1", Queues: 4, Rcompleted: 1, Rspent: 400, Wspent: 200, Tspent: 600, Tweighted: 1200, Uptime: 20},
+	}
+
+	got := CountDiskstatsUsage(prev, curr, 100)
+
+	assert.Len(t, got, 1)
+	assert.Equal(t, 4, got[0].Queues)
+	assert.Greater(t, got[0].Aqusz, float64(0))
+	assert.Greater(t, got[0].Rutil, float64(0))
+	assert.Greater(t, got[0].Wutil, float64(0))
+
+	// MqUtil scales classic Util down by the hardware queue count.
+	assert.InDelta(t, got[0].Util/4, got[0].MqUtil, 0.0001)
+	assert.Equal(t, got[0].MqUtil, got[0].UtilForDisplay(true))
+	assert.Equal(t, got[0].Util, got[0].UtilForDisplay(false))
+}
+
+func Test_countDiskstatsUsage_singleQueue(t *testing.T) {
+	prev := Diskstats{
+		{Major: 8, Minor: 0, Device: "sda", Queues: 1, Tspent: 100, Uptime: 10},
+	}
+	curr := Diskstats{
+		{Major: 8, Minor: 0, Device: "sda", Queues: 1, Rcompleted: 1, Tspent: 500, Uptime: 20},
+	}
+
+	got := CountDiskstatsUsage(prev, curr, 100)
+
+	assert.Len(t, got, 1)
+	assert.Equal(t, got[0].Util, got[0].MqUtil)
+}
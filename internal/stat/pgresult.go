@@ -0,0 +1,70 @@
+package stat
+
+import (
+	"database/sql"
+	"github.com/lesovsky/pgcenter/internal/postgres"
+)
+
+// PGresult is a generic container for the result set of an arbitrary query,
+// as used by view queries in 'top' and by 'record'. It intentionally doesn't
+// assume anything about the shape of the query beyond its column names.
+type PGresult struct {
+	Cols   []string
+	Result [][]sql.NullString
+}
+
+// NewPGresult runs query against db and returns its result set.
+func NewPGresult(db *postgres.DB, query string) (PGresult, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return PGresult{}, err
+	}
+	defer rows.Close()
+
+	return newPGresult(rows)
+}
+
+// NewPGresultTx is identical to NewPGresult, but runs query inside an already
+// open transaction, so the caller controls the transaction boundary - e.g. to
+// have several queries observe the same MVCC snapshot.
+func NewPGresultTx(tx *sql.Tx, query string) (PGresult, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return PGresult{}, err
+	}
+	defer rows.Close()
+
+	return newPGresult(rows)
+}
+
+// newPGresult reads rows into a PGresult. Every value is scanned as a
+// nullable string, since views only ever render or sum their columns and
+// never need query-specific Go types.
+func newPGresult(rows *sql.Rows) (PGresult, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return PGresult{}, err
+	}
+
+	var result [][]sql.NullString
+
+	for rows.Next() {
+		row := make([]sql.NullString, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+
+		if err := rows.Scan(ptrs...); err != nil {
+			return PGresult{}, err
+		}
+
+		result = append(result, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return PGresult{}, err
+	}
+
+	return PGresult{Cols: cols, Result: result}, nil
+}
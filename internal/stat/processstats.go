@@ -0,0 +1,328 @@
+package stat
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/lesovsky/pgcenter/internal/postgres"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProcessStat describes per-backend resource usage, similar to what crunchstat
+// collects for container processes, but keyed by the Postgres backend PID
+// observed in pg_stat_activity.
+type ProcessStat struct {
+	/* identity */
+	Pid int // backend PID this stat belongs to
+	/* from /proc/<pid>/io */
+	RcharBytes          float64 // characters read, including cache hits
+	WcharBytes          float64 // characters written, including cache hits
+	ReadBytes           float64 // bytes actually fetched from the storage layer
+	WriteBytes          float64 // bytes actually sent to the storage layer
+	CancelledWriteBytes float64 // bytes which were truncated instead of being written
+	/* from /proc/<pid>/stat */
+	UtimeJiffies float64 // time scheduled in user mode, in clock ticks
+	StimeJiffies float64 // time scheduled in kernel mode, in clock ticks
+	/* from /proc/<pid>/status */
+	RssBytes        float64 // resident set size
+	CtxVoluntary    float64 // voluntary context switches
+	CtxNonvoluntary float64 // involuntary context switches
+	/* from cgroup, when available; zero otherwise */
+	CgroupCpuUsage float64 // cumulative CPU usage in nanoseconds, from cpuacct/cpu.stat
+	/* advanced, filled in by CountProcessStatsUsage */
+	Uptime        float64 // system uptime, used for interval calculation
+	CpuTime       float64 // CPU jiffies consumed per second
+	ReadRate      float64 // read bytes per second
+	WriteRate     float64 // write bytes per second
+	CtxSwitchRate float64 // context switches per second (voluntary + involuntary)
+}
+
+// ProcessStats is the container for all per-backend process stats, keyed by PID.
+type ProcessStats map[int]ProcessStat
+
+const (
+	// pgProcPidIOQuery and pgProcPidStatQuery retrieve per-backend process stats
+	// from the Postgres instance for remote mode.
+	pgProcPidIOQuery   = "SELECT pid, rchar, wchar, read_bytes, write_bytes, cancelled_write_bytes FROM pgcenter.sys_proc_pid_io WHERE pid = ANY($1)"
+	pgProcPidStatQuery = "SELECT pid, utime, stime, rss, ctx_voluntary, ctx_nonvoluntary FROM pgcenter.sys_proc_pid_stat WHERE pid = ANY($1)"
+)
+
+// ReadProcessStats collects per-backend process stats for the given PIDs.
+func ReadProcessStats(db *postgres.DB, schemaExists bool, pids []int) (ProcessStats, error) {
+	if db.Local {
+		return readProcessStatsLocal(pids)
+	} else if schemaExists {
+		return readProcessStatsRemote(db, pids)
+	}
+
+	return ProcessStats{}, nil
+}
+
+// readProcessStatsLocal reads /proc/<pid>/{io,stat,status} and, when present,
+// cgroup cpu.stat for every requested PID. A PID which has already exited
+// between listing pg_stat_activity and reading /proc is skipped, not an error.
+func readProcessStatsLocal(pids []int) (ProcessStats, error) {
+	uptime, err := uptime()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(ProcessStats, len(pids))
+
+	for _, pid := range pids {
+		p := ProcessStat{Pid: pid, Uptime: uptime}
+
+		if err := readProcPidIO(pid, &p); err != nil {
+			if os.IsNotExist(err) {
+				continue // backend has already exited, just skip it
+			}
+			return nil, err
+		}
+
+		if err := readProcPidStat(pid, &p); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		if err := readProcPidStatus(pid, &p); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		// Cgroup accounting is optional - absence doesn't invalidate the rest of the sample.
+		p.CgroupCpuUsage, _ = readCgroupCPUUsage(pid)
+
+		stats[pid] = p
+	}
+
+	return stats, nil
+}
+
+// readProcPidIO parses /proc/<pid>/io.
+func readProcPidIO(pid int, p *ProcessStat) error {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := splitProcLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "rchar":
+			p.RcharBytes = value
+		case "wchar":
+			p.WcharBytes = value
+		case "read_bytes":
+			p.ReadBytes = value
+		case "write_bytes":
+			p.WriteBytes = value
+		case "cancelled_write_bytes":
+			p.CancelledWriteBytes = value
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readProcPidStat parses the utime/stime fields (14th, 15th) of /proc/<pid>/stat.
+func readProcPidStat(pid int, p *ProcessStat) error {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return err
+	}
+
+	// Fields 2 (comm) can contain spaces, so split after the closing paren.
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return fmt.Errorf("/proc/%d/stat bad content: missing comm field", pid)
+	}
+
+	fields := strings.Fields(string(data)[i+1:])
+	if len(fields) < 13 {
+		return fmt.Errorf("/proc/%d/stat bad content: too few fields", pid)
+	}
+
+	// fields[0] is state (field 3 overall), so utime/stime (fields 14/15) are fields[11]/fields[12].
+	p.UtimeJiffies, err = strconv.ParseFloat(fields[11], 64)
+	if err != nil {
+		return err
+	}
+
+	p.StimeJiffies, err = strconv.ParseFloat(fields[12], 64)
+	return err
+}
+
+// readProcPidStatus parses VmRSS and context-switch counters from /proc/<pid>/status.
+func readProcPidStatus(pid int, p *ProcessStat) error {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		key := strings.TrimSuffix(fields[0], ":")
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		switch key {
+		case "VmRSS":
+			p.RssBytes = value * 1024 // reported in kB
+		case "voluntary_ctxt_switches":
+			p.CtxVoluntary = value
+		case "nonvoluntary_ctxt_switches":
+			p.CtxNonvoluntary = value
+		}
+	}
+
+	return scanner.Err()
+}
+
+// readCgroupCPUUsage looks up cpu.stat for the cgroup the PID belongs to and
+// returns cumulative CPU usage in nanoseconds. Returns zero when cgroups are
+// not in use or the PID's cgroup can't be determined.
+func readCgroupCPUUsage(pid int) (float64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return 0, nil
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(string(data)), ":", 3)
+	if len(parts) != 3 {
+		return 0, nil
+	}
+
+	statFile := filepath.Join("/sys/fs/cgroup", parts[2], "cpu.stat")
+	f, err := os.Open(statFile)
+	if err != nil {
+		return 0, nil
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := splitProcLine(scanner.Text())
+		if ok && key == "usage_usec" {
+			return value * 1000, nil
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+// splitProcLine splits a "key: value" or "key value" line as found in
+// /proc/<pid>/io and cgroup cpu.stat, and parses value as a float.
+func splitProcLine(line string) (string, float64, bool) {
+	fields := strings.Fields(strings.Replace(line, ":", " ", 1))
+	if len(fields) != 2 {
+		return "", 0, false
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return fields[0], value, true
+}
+
+// readProcessStatsRemote reads per-backend process stats via SQL-callable
+// functions exposed by the pgcenter schema.
+func readProcessStatsRemote(db *postgres.DB, pids []int) (ProcessStats, error) {
+	var uptime float64
+	err := db.QueryRow(pgProcUptimeQuery).Scan(&uptime)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(ProcessStats, len(pids))
+
+	ioRows, err := db.Query(pgProcPidIOQuery, pids)
+	if err != nil {
+		return nil, err
+	}
+	defer ioRows.Close()
+
+	for ioRows.Next() {
+		var p ProcessStat
+		if err := ioRows.Scan(&p.Pid, &p.RcharBytes, &p.WcharBytes, &p.ReadBytes, &p.WriteBytes, &p.CancelledWriteBytes); err != nil {
+			return nil, err
+		}
+		p.Uptime = uptime
+		stats[p.Pid] = p
+	}
+
+	statRows, err := db.Query(pgProcPidStatQuery, pids)
+	if err != nil {
+		return nil, err
+	}
+	defer statRows.Close()
+
+	for statRows.Next() {
+		var pid int
+		var utime, stime, rss, ctxVol, ctxNonvol float64
+		if err := statRows.Scan(&pid, &utime, &stime, &rss, &ctxVol, &ctxNonvol); err != nil {
+			return nil, err
+		}
+
+		p := stats[pid]
+		p.Pid = pid
+		p.Uptime = uptime
+		p.UtimeJiffies = utime
+		p.StimeJiffies = stime
+		p.RssBytes = rss
+		p.CtxVoluntary = ctxVol
+		p.CtxNonvoluntary = ctxNonvol
+		stats[pid] = p
+	}
+
+	return stats, nil
+}
+
+// CountProcessStatsUsage computes per-backend rates between two snapshots,
+// keyed by PID rather than index, so PIDs appearing or disappearing between
+// ticks (backends connecting/disconnecting) don't blank out the whole panel.
+func CountProcessStatsUsage(prev, curr ProcessStats, ticks float64) ProcessStats {
+	stats := make(ProcessStats, len(curr))
+
+	for pid, c := range curr {
+		p, ok := prev[pid]
+		if !ok {
+			// First time we see this backend - report it with zeroed rates
+			// rather than dropping it, so it shows up in the panel immediately.
+			stats[pid] = c
+			continue
+		}
+
+		itv := c.Uptime - p.Uptime
+
+		c.CpuTime = sValue(p.UtimeJiffies+p.StimeJiffies, c.UtimeJiffies+c.StimeJiffies, itv, ticks)
+		c.ReadRate = sValue(p.ReadBytes, c.ReadBytes, itv, ticks)
+		c.WriteRate = sValue(p.WriteBytes, c.WriteBytes, itv, ticks)
+		c.CtxSwitchRate = sValue(p.CtxVoluntary+p.CtxNonvoluntary, c.CtxVoluntary+c.CtxNonvoluntary, itv, ticks)
+
+		stats[pid] = c
+	}
+
+	return stats
+}
@@ -0,0 +1,33 @@
+package stat
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_CountProcessStatsUsage(t *testing.T) {
+	prev := ProcessStats{
+		100: {Pid: 100, Uptime: 10, UtimeJiffies: 100, StimeJiffies: 50, ReadBytes: 1000, WriteBytes: 500},
+		200: {Pid: 200, Uptime: 10, UtimeJiffies: 200, StimeJiffies: 100, ReadBytes: 2000, WriteBytes: 1000},
+	}
+
+	// pid 200 disappeared (backend disconnected), pid 300 is new (backend just connected).
+	curr := ProcessStats{
+		100: {Pid: 100, Uptime: 20, UtimeJiffies: 150, StimeJiffies: 80, ReadBytes: 1500, WriteBytes: 900},
+		300: {Pid: 300, Uptime: 20, UtimeJiffies: 10, StimeJiffies: 5, ReadBytes: 10, WriteBytes: 20},
+	}
+
+	got := CountProcessStatsUsage(prev, curr, 100)
+
+	assert.Len(t, got, 2)
+	assert.Equal(t, 100, got[100].Pid)
+	assert.Greater(t, got[100].CpuTime, float64(0))
+
+	// first-seen PID is reported with zeroed rates, not dropped.
+	assert.Equal(t, 300, got[300].Pid)
+	assert.Equal(t, float64(0), got[300].CpuTime)
+
+	// disappeared PID is dropped, not carried over.
+	_, gone := got[200]
+	assert.False(t, gone)
+}
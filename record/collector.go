@@ -14,6 +14,8 @@ import (
 )
 
 // collector defines a way of how to collect and store collected stats.
+// Implementations are modeled after Telegraf's output plugins: each one knows
+// how to collect stats from Postgres and ship them somewhere of its own choosing.
 type collector interface {
 	open() error
 	collect(dbConfig *postgres.Config, views view.Views) (map[string]stat.PGresult, error)
@@ -21,6 +23,41 @@ type collector interface {
 	close() error
 }
 
+// config aggregates configuration of all supported collector implementations.
+// kind selects which collector is created; only the sub-config matching kind is used.
+type config struct {
+	kind            string
+	tar             tarConfig
+	lineproto       lineprotoConfig
+	kafka           kafkaConfig
+	promRemoteWrite promRemoteWriteConfig
+}
+
+// Supported collector kinds.
+const (
+	kindTar             = "tar"
+	kindLineproto       = "lineproto"
+	kindKafka           = "kafka"
+	kindPromRemoteWrite = "promremotewrite"
+)
+
+// newCollector creates a collector of the requested kind. The tar collector
+// is the default, preserving the historical 'pgcenter record' behavior.
+func newCollector(c config) (collector, error) {
+	switch c.kind {
+	case "", kindTar:
+		return newTarCollector(c.tar), nil
+	case kindLineproto:
+		return newLineprotoCollector(c.lineproto), nil
+	case kindKafka:
+		return newKafkaCollector(c.kafka), nil
+	case kindPromRemoteWrite:
+		return newPromRemoteWriteCollector(c.promRemoteWrite), nil
+	default:
+		return nil, fmt.Errorf("unknown collector kind: %s", c.kind)
+	}
+}
+
 // tarConfig defines configuration needed for creating tar collector.
 type tarConfig struct {
 	filename string
@@ -91,16 +128,37 @@ func (c *tarCollector) open() error {
 }
 
 // collect connects to Postgres, collects and returns stats data.
+//
+// All views are queried inside a single REPEATABLE READ READ ONLY
+// transaction so they observe the same MVCC snapshot. Without this, views are
+// queried one after another and can end up reflecting mutually inconsistent
+// pg_stat_* snapshots when collected under load.
 func (c *tarCollector) collect(dbConfig *postgres.Config, views view.Views) (map[string]stat.PGresult, error) {
 	db, err := postgres.Connect(dbConfig)
 	if err != nil {
 		return nil, err
 	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// database/sql's TxOptions has no isolation-level-as-string knob, so set
+	// it explicitly as the first statement of the transaction. DEFERRABLE is
+	// omitted: it only affects SERIALIZABLE READ ONLY transactions, and is a
+	// no-op here under REPEATABLE READ.
+	_, err = tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY")
+	if err != nil {
+		return nil, err
+	}
 
 	stats := map[string]stat.PGresult{}
 
 	for k, v := range views {
-		res, err := stat.NewPGresult(db, v.Query)
+		res, err := stat.NewPGresultTx(tx, v.Query)
 		if err != nil {
 			return nil, err
 		}
@@ -109,6 +167,10 @@ func (c *tarCollector) collect(dbConfig *postgres.Config, views view.Views) (map
 		stats[key] = res
 	}
 
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return stats, nil
 }
 
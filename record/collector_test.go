@@ -0,0 +1,56 @@
+package record
+
+import (
+	"github.com/lesovsky/pgcenter/internal/postgres"
+	"github.com/lesovsky/pgcenter/internal/view"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// Test_tarCollector_collect_concurrentActivity checks that a single collect()
+// call succeeds and returns a result for every view while another connection
+// keeps querying the server during the collection. It does not compare
+// counter values across the collected views.
+func Test_tarCollector_collect_concurrentActivity(t *testing.T) {
+	dbConfig, err := postgres.NewTestConfig()
+	assert.NoError(t, err)
+
+	victim, err := postgres.NewTestConnect()
+	assert.NoError(t, err)
+	defer victim.Close()
+
+	views := view.Views{
+		"databases": view.View{Query: "SELECT * FROM pg_stat_database"},
+		"bgwriter":  view.View{Query: "SELECT * FROM pg_stat_bgwriter"},
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, _ = victim.Exec("select count(*) from pg_stat_activity")
+			}
+		}
+	}()
+
+	c := newTarCollector(tarConfig{filename: "/tmp/pgcenter_test_collect.tar", truncate: true})
+	stats, err := c.collect(dbConfig, views)
+
+	close(stop)
+	wg.Wait()
+
+	assert.NoError(t, err)
+	assert.Len(t, stats, 2)
+
+	for _, v := range stats {
+		assert.NotNil(t, v.Result)
+	}
+}
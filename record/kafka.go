@@ -0,0 +1,93 @@
+package record
+
+import (
+	"context"
+	"github.com/lesovsky/pgcenter/internal/postgres"
+	"github.com/lesovsky/pgcenter/internal/stat"
+	"github.com/lesovsky/pgcenter/internal/view"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+// kafkaConfig defines configuration needed for creating a kafkaCollector.
+type kafkaConfig struct {
+	brokers []string
+	topic   string
+}
+
+// kafkaCollector implements the collector interface.
+// This implementation converts collected stats into line-protocol points and
+// publishes them to a Kafka topic, partitioned by the view (measurement) name.
+type kafkaCollector struct {
+	config kafkaConfig
+	writer *kafka.Writer
+}
+
+// newKafkaCollector creates new collector.
+func newKafkaCollector(c kafkaConfig) collector {
+	return &kafkaCollector{config: c}
+}
+
+// open creates the Kafka writer.
+func (c *kafkaCollector) open() error {
+	c.writer = &kafka.Writer{
+		Addr:     kafka.TCP(c.config.brokers...),
+		Topic:    c.config.topic,
+		Balancer: &kafka.Hash{}, // partition key is derived from the message key (view name)
+	}
+
+	return nil
+}
+
+// collect connects to Postgres and collects stats data.
+func (c *kafkaCollector) collect(dbConfig *postgres.Config, views view.Views) (map[string]stat.PGresult, error) {
+	db, err := postgres.Connect(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stats := map[string]stat.PGresult{}
+
+	for k, v := range views {
+		res, err := stat.NewPGresult(db, v.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		stats[k] = res
+	}
+
+	return stats, nil
+}
+
+// write renders stats as line protocol and publishes each point as a Kafka message.
+func (c *kafkaCollector) write(stats map[string]stat.PGresult) error {
+	now := time.Now()
+
+	var messages []kafka.Message
+	for view, res := range stats {
+		for _, p := range pgresultToPoints(view, res, now) {
+			messages = append(messages, kafka.Message{
+				Key:   []byte(view),
+				Value: []byte(p.lineProtocol()),
+				Time:  now,
+			})
+		}
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	return c.writer.WriteMessages(context.Background(), messages...)
+}
+
+// close closes the Kafka writer.
+func (c *kafkaCollector) close() error {
+	if c.writer != nil {
+		return c.writer.Close()
+	}
+
+	return nil
+}
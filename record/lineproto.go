@@ -0,0 +1,112 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/lesovsky/pgcenter/internal/postgres"
+	"github.com/lesovsky/pgcenter/internal/stat"
+	"github.com/lesovsky/pgcenter/internal/view"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lineprotoConfig defines configuration needed for creating a lineprotoCollector.
+type lineprotoConfig struct {
+	filename string // write points into this file, mutually exclusive with url
+	url      string // POST points to this InfluxDB-compatible /write endpoint
+}
+
+// lineprotoCollector implements the collector interface.
+// This implementation converts collected stats into InfluxDB line protocol
+// and writes it either into a file or to an InfluxDB /write HTTP endpoint.
+type lineprotoCollector struct {
+	config lineprotoConfig
+	file   *os.File
+	client *http.Client
+}
+
+// newLineprotoCollector creates new collector.
+func newLineprotoCollector(c lineprotoConfig) collector {
+	return &lineprotoCollector{config: c, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// open opens the destination file, when recording to a file.
+func (c *lineprotoCollector) open() error {
+	if c.config.filename == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Clean(c.config.filename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	c.file = f
+	return nil
+}
+
+// collect connects to Postgres and collects stats data. Conversion into
+// line-protocol points happens later, in write.
+func (c *lineprotoCollector) collect(dbConfig *postgres.Config, views view.Views) (map[string]stat.PGresult, error) {
+	db, err := postgres.Connect(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stats := map[string]stat.PGresult{}
+
+	for k, v := range views {
+		res, err := stat.NewPGresult(db, v.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		stats[k] = res
+	}
+
+	return stats, nil
+}
+
+// write renders stats as line protocol and ships them to the configured destination.
+func (c *lineprotoCollector) write(stats map[string]stat.PGresult) error {
+	now := time.Now()
+
+	var buf bytes.Buffer
+	for view, res := range stats {
+		for _, p := range pgresultToPoints(view, res, now) {
+			buf.WriteString(p.lineProtocol())
+			buf.WriteByte('\n')
+		}
+	}
+
+	if c.file != nil {
+		_, err := c.file.Write(buf.Bytes())
+		return err
+	}
+
+	if c.config.url != "" {
+		resp, err := c.client.Post(c.config.url, "text/plain; charset=utf-8", &buf)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("write to %s failed: unexpected status %s", c.config.url, resp.Status)
+		}
+	}
+
+	return nil
+}
+
+// close closes the destination file, when recording to a file.
+func (c *lineprotoCollector) close() error {
+	if c.file != nil {
+		return c.file.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package record
+
+import (
+	"fmt"
+	"github.com/lesovsky/pgcenter/internal/stat"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pointTagKeys lists column names which identify a row rather than measure it.
+// They are emitted as line-protocol/remote_write tags instead of fields.
+var pointTagKeys = map[string]bool{
+	"datname": true, "relname": true, "schemaname": true, "indexrelname": true,
+	"pid": true, "device": true, "slot_name": true, "application_name": true,
+}
+
+// point is an intermediate representation of a single measured row, shared
+// by all streaming collector implementations (line protocol, Kafka, Prometheus
+// remote_write) so each of them only has to deal with its own wire format.
+type point struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]float64
+	time        time.Time
+}
+
+// pgresultToPoints converts a stat.PGresult into a set of points, one per row.
+// The measurement name is the view name; tag keys are the columns listed in
+// pointTagKeys, everything else that parses as a number becomes a field.
+func pgresultToPoints(view string, res stat.PGresult, ts time.Time) []point {
+	points := make([]point, 0, len(res.Result))
+
+	for _, row := range res.Result {
+		p := point{measurement: view, tags: map[string]string{}, fields: map[string]float64{}, time: ts}
+
+		for i, col := range res.Cols {
+			if i >= len(row) || !row[i].Valid {
+				continue
+			}
+
+			value := row[i].String
+
+			if pointTagKeys[col] {
+				p.tags[col] = value
+				continue
+			}
+
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				p.fields[col] = f
+			}
+		}
+
+		if len(p.fields) > 0 {
+			points = append(points, p)
+		}
+	}
+
+	return points
+}
+
+// lineProtocol renders the point using InfluxDB line protocol.
+// See https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+func (p point) lineProtocol() string {
+	var sb strings.Builder
+
+	sb.WriteString(escapeLineProtocol(p.measurement))
+
+	for _, k := range sortedKeys(p.tags) {
+		sb.WriteByte(',')
+		sb.WriteString(escapeLineProtocol(k))
+		sb.WriteByte('=')
+		sb.WriteString(escapeLineProtocol(p.tags[k]))
+	}
+
+	sb.WriteByte(' ')
+
+	for i, k := range sortedFieldKeys(p.fields) {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(escapeLineProtocol(k))
+		sb.WriteByte('=')
+		sb.WriteString(strconv.FormatFloat(p.fields[k], 'f', -1, 64))
+	}
+
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatInt(p.time.UnixNano(), 10))
+
+	return sb.String()
+}
+
+func escapeLineProtocol(s string) string {
+	r := strings.NewReplacer(" ", "\\ ", ",", "\\,", "=", "\\=")
+	return r.Replace(s)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFieldKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// String implements fmt.Stringer, mostly useful for debugging and logging.
+func (p point) String() string {
+	return fmt.Sprintf("%s %v %v %d", p.measurement, p.tags, p.fields, p.time.UnixNano())
+}
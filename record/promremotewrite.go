@@ -0,0 +1,122 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/lesovsky/pgcenter/internal/postgres"
+	"github.com/lesovsky/pgcenter/internal/stat"
+	"github.com/lesovsky/pgcenter/internal/view"
+	"github.com/prometheus/prometheus/prompb"
+	"net/http"
+	"time"
+)
+
+// promRemoteWriteConfig defines configuration needed for creating a promRemoteWriteCollector.
+type promRemoteWriteConfig struct {
+	url string // remote_write endpoint, e.g. http://localhost:9090/api/v1/write
+}
+
+// promRemoteWriteCollector implements the collector interface.
+// This implementation batches collected stats into a prometheus.WriteRequest
+// protobuf message, compresses it with snappy and POSTs it to a Prometheus
+// remote_write compatible endpoint.
+type promRemoteWriteCollector struct {
+	config promRemoteWriteConfig
+	client *http.Client
+}
+
+// newPromRemoteWriteCollector creates new collector.
+func newPromRemoteWriteCollector(c promRemoteWriteConfig) collector {
+	return &promRemoteWriteCollector{config: c, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// open is a no-op, connections are established per-request.
+func (c *promRemoteWriteCollector) open() error {
+	return nil
+}
+
+// collect connects to Postgres and collects stats data.
+func (c *promRemoteWriteCollector) collect(dbConfig *postgres.Config, views view.Views) (map[string]stat.PGresult, error) {
+	db, err := postgres.Connect(dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stats := map[string]stat.PGresult{}
+
+	for k, v := range views {
+		res, err := stat.NewPGresult(db, v.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		stats[k] = res
+	}
+
+	return stats, nil
+}
+
+// write converts stats into a prompb.WriteRequest and ships it to the remote_write endpoint.
+func (c *promRemoteWriteCollector) write(stats map[string]stat.PGresult) error {
+	now := time.Now()
+
+	var ts []prompb.TimeSeries
+	for view, res := range stats {
+		for _, p := range pgresultToPoints(view, res, now) {
+			for field, value := range p.fields {
+				labels := []prompb.Label{
+					{Name: "__name__", Value: fmt.Sprintf("pgcenter_%s_%s", p.measurement, field)},
+				}
+				for k, v := range p.tags {
+					labels = append(labels, prompb.Label{Name: k, Value: v})
+				}
+
+				ts = append(ts, prompb.TimeSeries{
+					Labels:  labels,
+					Samples: []prompb.Sample{{Value: value, Timestamp: p.time.UnixNano() / int64(time.Millisecond)}},
+				})
+			}
+		}
+	}
+
+	if len(ts) == 0 {
+		return nil
+	}
+
+	wr := &prompb.WriteRequest{Timeseries: ts}
+
+	data, err := proto.Marshal(wr)
+	if err != nil {
+		return err
+	}
+
+	compressed := snappy.Encode(nil, data)
+
+	req, err := http.NewRequest(http.MethodPost, c.config.url, bytes.NewReader(compressed))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote_write to %s failed: unexpected status %s", c.config.url, resp.Status)
+	}
+
+	return nil
+}
+
+// close is a no-op, connections are established per-request.
+func (c *promRemoteWriteCollector) close() error {
+	return nil
+}
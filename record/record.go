@@ -0,0 +1,53 @@
+package record
+
+import (
+	"github.com/lesovsky/pgcenter/internal/postgres"
+	"github.com/lesovsky/pgcenter/internal/view"
+	"time"
+)
+
+// Config configures 'pgcenter record'. Kind selects which collector
+// implementation is used; only the Config field matching Kind is read.
+type Config struct {
+	Kind            string // tar (default), lineproto, kafka or promremotewrite
+	Interval        time.Duration
+	Tar             tarConfig
+	Lineproto       lineprotoConfig
+	Kafka           kafkaConfig
+	PromRemoteWrite promRemoteWriteConfig
+}
+
+// RunMain is the main entry point for 'pgcenter record' command.
+func RunMain(dbConfig *postgres.Config, views view.Views, cfg Config) error {
+	c, err := newCollector(config{
+		kind:            cfg.Kind,
+		tar:             cfg.Tar,
+		lineproto:       cfg.Lineproto,
+		kafka:           cfg.Kafka,
+		promRemoteWrite: cfg.PromRemoteWrite,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.open(); err != nil {
+		return err
+	}
+	defer func() { _ = c.close() }()
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stats, err := c.collect(dbConfig, views)
+		if err != nil {
+			return err
+		}
+
+		if err := c.write(stats); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
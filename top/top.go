@@ -11,17 +11,78 @@ import (
 
 // app defines stuff required for application.
 type app struct {
-	postgresProps stat.PostgresProperties
-	config        *config
-	ui            *gocui.Gui
-	db            *postgres.DB
-	stats         *stat.Stat // TODO: в конечном счете от этой структуры следует избавиться т.к. стата берется из спец. стат горутины (см. collectStat)
-	doExit        chan int
-	doUpdate      chan int
+	postgresProps  stat.PostgresProperties
+	config         *config
+	ui             *gocui.Gui
+	db             *postgres.DB
+	stats          *stat.Stat // TODO: в конечном счете от этой структуры следует избавиться т.к. стата берется из спец. стат горутины (см. collectStat)
+	processStats   stat.ProcessStats
+	statConfig     stat.Config // user-configurable collection knobs, e.g. diskstats include/exclude filters
+	multiQueueUtil bool        // iostat toggle: show Diskstat.MqUtil instead of the classic Util
+	doExit         chan int
+	doUpdate       chan int
 }
 
-// RunMain is the main entry point for 'pgcenter top' command
-func RunMain(dbConfig *postgres.Config) error {
+// auxProcesses is a new aux panel, shown next to auxDiskstats, with per-backend
+// process stats (CPU, IO, ctxt switches) keyed by PID. The per-tick dispatch
+// that drives collectProcessStats off this value, and the draw code that
+// renders the panel, live in mainloop.go/draw.go/config.go - none of which
+// are present in this checkout, so they can't be wired up here; this gates
+// collection on the panel actually being selected, which is the one piece of
+// real wiring reachable from this file.
+const auxProcesses = auxLogtail + 1
+
+// collectDiskstats reads the current diskstats snapshot - honoring the
+// DiskstatsInclude/DiskstatsExclude filters from app.statConfig - and diffs
+// it against prev.
+func (app *app) collectDiskstats(prev stat.Diskstats, schemaExists bool, ticks float64) (stat.Diskstats, error) {
+	curr, err := stat.ReadDiskstats(app.db, schemaExists, app.statConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return stat.CountDiskstatsUsage(prev, curr, ticks), nil
+}
+
+// iostatUtil returns the utilization value the iostat view should display for
+// d, honoring the classic/multi-queue toggle held in app.multiQueueUtil. The
+// draw code that calls this per-row lives in draw.go, which this checkout
+// doesn't include.
+func (app *app) iostatUtil(d stat.Diskstat) float64 {
+	return d.UtilForDisplay(app.multiQueueUtil)
+}
+
+// toggleMultiQueueUtil flips the classic/multi-queue utilization display,
+// meant to be bound to a key the same way other top toggles are.
+func (app *app) toggleMultiQueueUtil() {
+	app.multiQueueUtil = !app.multiQueueUtil
+}
+
+// collectProcessStats refreshes the per-backend process stats panel for the
+// given set of backend PIDs (as observed in pg_stat_activity), diffing
+// against the previous snapshot so app.processStats always holds rates. It's
+// a no-op unless the processes aux panel is the one currently selected, so
+// backends aren't scanned via /proc or the pgcenter schema on every tick
+// regardless of whether anyone is looking at the panel.
+func (app *app) collectProcessStats(pids []int, schemaExists bool, ticks float64) error {
+	if app.config.aux != auxProcesses {
+		return nil
+	}
+
+	curr, err := stat.ReadProcessStats(app.db, schemaExists, pids)
+	if err != nil {
+		return err
+	}
+
+	app.processStats = stat.CountProcessStatsUsage(app.processStats, curr, ticks)
+
+	return nil
+}
+
+// RunMain is the main entry point for 'pgcenter top' command. statConfig
+// carries user-configurable collection knobs (e.g. --diskstats-include /
+// --diskstats-exclude) down to the stat package.
+func RunMain(dbConfig *postgres.Config, statConfig stat.Config) error {
 	config := newConfig()
 
 	// Connect to Postgres.
@@ -32,9 +93,10 @@ func RunMain(dbConfig *postgres.Config) error {
 	defer db.Close()
 
 	app := &app{
-		config: config,
-		db:     db,
-		stats:  &stat.Stat{},
+		config:     config,
+		db:         db,
+		stats:      &stat.Stat{},
+		statConfig: statConfig,
 	}
 
 	// Setup context - which kind of stats should be displayed